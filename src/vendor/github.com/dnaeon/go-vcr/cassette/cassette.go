@@ -0,0 +1,177 @@
+// Copyright (c) 2015-2016 Marin Atanasov Nikolov <dnaeon@gmail.com>
+// Copyright (c) 2016 David Jack <davars@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer
+//    in this position and unchanged.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR(S) ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES
+// OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE AUTHOR(S) BE LIABLE FOR ANY DIRECT, INDIRECT,
+// INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT
+// NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+// THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package cassette provides the on-disk representation recorded and
+// replayed by the recorder package.
+package cassette
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"gopkg.in/yaml.v2"
+)
+
+// cassetteFormatVersion is the format of the yaml file on disk
+const cassetteFormatVersion = 1
+
+// Request represents a client request as recorded in the cassette
+type Request struct {
+	// Body of request
+	Body string `yaml:"body"`
+
+	// Form values
+	Form url.Values `yaml:"form"`
+
+	// Request headers
+	Headers http.Header `yaml:"headers"`
+
+	// Request URL
+	URL string `yaml:"url"`
+
+	// Request method
+	Method string `yaml:"method"`
+}
+
+// Response represents a server response as recorded in the cassette
+type Response struct {
+	// Body of response. Empty when BodyFile is set.
+	Body string `yaml:"body"`
+
+	// BodyFile, if set, is the path to a file on disk holding the response
+	// body, used instead of Body in streaming mode so multi-MB fixtures
+	// don't have to live in the yaml file or in memory.
+	BodyFile string `yaml:"body_file,omitempty"`
+
+	// Response headers
+	Headers http.Header `yaml:"headers"`
+
+	// Response status message
+	Status string `yaml:"status"`
+
+	// Response status code
+	Code int `yaml:"code"`
+
+	// Proto is the response's HTTP protocol version, e.g. "HTTP/1.1"
+	Proto string `yaml:"proto,omitempty"`
+
+	// ProtoMajor is the major version number of Proto
+	ProtoMajor int `yaml:"proto_major,omitempty"`
+
+	// ProtoMinor is the minor version number of Proto
+	ProtoMinor int `yaml:"proto_minor,omitempty"`
+
+	// Trailer holds the trailing headers observed on the original response
+	Trailer http.Header `yaml:"trailer,omitempty"`
+
+	// TransferEncoding is the transfer encodings observed on the original
+	// response, outermost first
+	TransferEncoding []string `yaml:"transfer_encoding,omitempty"`
+}
+
+// Interaction type contains a pair of request/response for a
+// single HTTP interaction between a client and a server
+type Interaction struct {
+	Request  Request  `yaml:"request"`
+	Response Response `yaml:"response"`
+
+	// Played marks whether this interaction has already been returned
+	// during sequential replay. Set by recorder.findInteraction, which
+	// does its own matching instead of going through GetInteraction below.
+	Played bool `yaml:"-"`
+}
+
+// Cassette type
+type Cassette struct {
+	// Name of the cassette
+	Name string `yaml:"-"`
+
+	// File name of the cassette
+	File string `yaml:"-"`
+
+	// Version of the cassette format
+	Version int `yaml:"version"`
+
+	// Interactions between client and server
+	Interactions []*Interaction `yaml:"interactions"`
+}
+
+// New creates a new empty cassette
+func New(name string) *Cassette {
+	c := &Cassette{
+		Name:         name,
+		File:         fmt.Sprintf("%s.yaml", name),
+		Version:      cassetteFormatVersion,
+		Interactions: make([]*Interaction, 0),
+	}
+
+	return c
+}
+
+// Load reads a cassette file from disk
+func Load(name string) (*Cassette, error) {
+	c := New(name)
+	data, err := ioutil.ReadFile(c.File)
+	if err != nil {
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(data, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Save writes the cassette data on disk for future re-use
+func (c *Cassette) Save() error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(c.File, data, 0644)
+}
+
+// AddInteraction appends a new interaction to the cassette
+func (c *Cassette) AddInteraction(i *Interaction) {
+	c.Interactions = append(c.Interactions, i)
+}
+
+// GetInteraction returns the first recorded interaction matching r by
+// method and URL. It ignores Played and custom matchers; the recorder
+// package uses its own findInteraction instead.
+func (c *Cassette) GetInteraction(r *http.Request) (*Interaction, error) {
+	for _, i := range c.Interactions {
+		if r.Method == i.Request.Method && r.URL.String() == i.Request.URL {
+			return i, nil
+		}
+	}
+
+	return nil, fmt.Errorf("requested interaction not found")
+}
@@ -33,22 +33,60 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"os"
 
 	"github.com/dnaeon/go-vcr/cassette"
 )
 
+// Mode is the operating mode of a Recorder.
+type Mode int
+
 // Recorder states
 const (
-	ModeRecording = iota
+	ModeRecording Mode = iota
 	ModeReplaying
+	// ModeDisabled passes every request straight through to the real
+	// transport without touching the cassette at all.
+	ModeDisabled
+)
+
+// Passthrough is a predicate that bypasses recording/replay for requests it
+// matches, sending them straight to the real transport.
+type Passthrough func(r *http.Request) bool
+
+// Matcher determines whether a live request matches a previously recorded
+// interaction's request. The default compares only the HTTP method and URL.
+type Matcher func(r *http.Request, i cassette.Request) bool
+
+// DefaultMatcher matches requests on method and URL, ignoring the body.
+func DefaultMatcher(r *http.Request, i cassette.Request) bool {
+	return r.Method == i.Method && r.URL.String() == i.URL
+}
+
+// ReplayMode controls how findInteraction picks among interactions that
+// share the same request signature.
+type ReplayMode int
+
+const (
+	// ReplayModeAny returns the first matching interaction regardless of
+	// whether it has already been played. This is the default.
+	ReplayModeAny ReplayMode = iota
+	// ReplayModeSequential walks interactions in recorded order, returning
+	// the next one that hasn't been played yet for a given signature.
+	ReplayModeSequential
 )
 
+// Filter mutates a recorded interaction before it's persisted or handed
+// back to the caller. Returning an error aborts the request; the error
+// RoundTrip returns includes that error's message.
+type Filter func(i *cassette.Interaction) error
+
 // Recorder represents a type used to record and replay
 // client and server interactions
 type Recorder struct {
 	// Operating mode of the recorder
-	mode int
+	mode Mode
 
 	// Cassette used by the recorder
 	cassette *cassette.Cassette
@@ -57,11 +95,216 @@ type Recorder struct {
 	Transport *Transport
 }
 
+// SetMatcher sets the matcher used during replay. Passing nil restores
+// DefaultMatcher.
+func (r *Recorder) SetMatcher(matcher Matcher) {
+	if matcher == nil {
+		matcher = DefaultMatcher
+	}
+	r.Transport.matcher = matcher
+}
+
+// AddSaveFilter registers a filter run on an interaction before it's added
+// to the cassette.
+func (r *Recorder) AddSaveFilter(filter Filter) {
+	r.Transport.saveFilters = append(r.Transport.saveFilters, filter)
+}
+
+// AddFilter is an alias for AddSaveFilter.
+func (r *Recorder) AddFilter(filter Filter) {
+	r.AddSaveFilter(filter)
+}
+
+// AddResponseFilter registers a filter run on an interaction before it's
+// turned into the http.Response handed back to the caller.
+func (r *Recorder) AddResponseFilter(filter Filter) {
+	r.Transport.responseFilters = append(r.Transport.responseFilters, filter)
+}
+
+// AddPassthrough registers a predicate that bypasses the cassette for
+// matching requests, sending them straight to the real transport.
+func (r *Recorder) AddPassthrough(passthrough Passthrough) {
+	r.Transport.passthroughs = append(r.Transport.passthroughs, passthrough)
+}
+
+// SetReplayMode controls how a matching interaction is picked when several
+// recorded interactions share the same request signature.
+func (r *Recorder) SetReplayMode(mode ReplayMode) {
+	r.Transport.replayMode = mode
+}
+
+// ReplayableInteractions sets whether a played interaction can still be
+// matched again. Default false: in ReplayModeSequential each interaction is
+// consumed once.
+func (r *Recorder) ReplayableInteractions(replayable bool) {
+	r.Transport.replayable = replayable
+}
+
+// Rewind clears the played bookmark on every interaction in the cassette.
+func (r *Recorder) Rewind() {
+	for _, i := range r.cassette.Interactions {
+		i.Played = false
+	}
+}
+
+// SetStreaming toggles streaming mode. When enabled, recorded response
+// bodies are written to a file next to the cassette instead of being
+// buffered in memory, and served back on replay via that file.
+func (r *Recorder) SetStreaming(streaming bool) {
+	r.Transport.streaming = streaming
+}
+
+// OnRequest registers a callback invoked with every request that goes
+// through the cassette, before it's looked up or sent. Requests sent
+// straight to the real transport in ModeDisabled or by a passthrough rule
+// don't reach it.
+func (r *Recorder) OnRequest(hook func(r *http.Request)) {
+	r.Transport.onRequest = append(r.Transport.onRequest, hook)
+}
+
+// OnResponse registers a callback invoked with the interaction chosen for a
+// request, whether it came from the cassette or a live call.
+func (r *Recorder) OnResponse(hook func(i *cassette.Interaction)) {
+	r.Transport.onResponse = append(r.Transport.onResponse, hook)
+}
+
+// OnCassetteLoad registers a callback invoked with the recorder's cassette.
+// Cassettes load synchronously in New/NewWithOptions, so this fires
+// immediately.
+func (r *Recorder) OnCassetteLoad(hook func(c *cassette.Cassette)) {
+	hook(r.cassette)
+}
+
+// applyFilters runs filters over i in order, stopping at the first error.
+func applyFilters(i *cassette.Interaction, filters []Filter) error {
+	for _, filter := range filters {
+		if err := filter(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cloneInteraction returns a copy of i, deep-copying the reference-typed
+// parts of its Request and Response, so a filter applied to the clone can't
+// mutate the interaction already handed to c.AddInteraction.
+func cloneInteraction(i *cassette.Interaction) *cassette.Interaction {
+	clone := *i
+	clone.Request.Headers = cloneHeader(i.Request.Headers)
+	clone.Request.Form = cloneValues(i.Request.Form)
+	clone.Response.Headers = cloneHeader(i.Response.Headers)
+	clone.Response.Trailer = cloneHeader(i.Response.Trailer)
+	clone.Response.TransferEncoding = append([]string(nil), i.Response.TransferEncoding...)
+	return &clone
+}
+
+// cloneHeader returns a deep copy of h.
+func cloneHeader(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	clone := make(http.Header, len(h))
+	for k, v := range h {
+		clone[k] = append([]string(nil), v...)
+	}
+	return clone
+}
+
+// cloneValues returns a deep copy of v.
+func cloneValues(v url.Values) url.Values {
+	if v == nil {
+		return nil
+	}
+	clone := make(url.Values, len(v))
+	for k, vv := range v {
+		clone[k] = append([]string(nil), vv...)
+	}
+	return clone
+}
+
+// bufferRequestBody reads r's body, if any, into memory and restores r.Body
+// via a fresh reader so later code can still consume it. hadBody reports
+// whether r.Body was non-nil, so callers can leave a nil body nil instead of
+// replacing it with a non-nil empty reader.
+func bufferRequestBody(r *http.Request) (body string, hadBody bool, err error) {
+	if r.Body == nil {
+		return "", false, nil
+	}
+
+	var buf bytes.Buffer
+	content, err := ioutil.ReadAll(io.TeeReader(r.Body, &buf))
+	if err != nil {
+		return "", false, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewBuffer(buf.Bytes()))
+
+	return string(content), true, nil
+}
+
+// streamBodyToFile copies body to a file alongside the cassette and returns
+// its path.
+func streamBodyToFile(c *cassette.Cassette, body io.Reader) (string, error) {
+	path := fmt.Sprintf("%s.%d.body", c.Name, len(c.Interactions))
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, body); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// findInteraction returns a recorded interaction whose request satisfies
+// matcher against r. In ReplayModeSequential, already-played interactions
+// are skipped (unless replayable is set) so that repeated calls to the same
+// endpoint walk the cassette in recorded order instead of always returning
+// the first match.
+func findInteraction(c *cassette.Cassette, r *http.Request, matcher Matcher, replayMode ReplayMode, replayable bool) (*cassette.Interaction, error) {
+	body, hadBody, err := bufferRequestBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range c.Interactions {
+		if replayMode == ReplayModeSequential && i.Played && !replayable {
+			continue
+		}
+
+		if hadBody {
+			r.Body = ioutil.NopCloser(bytes.NewBufferString(body))
+		}
+		if matcher(r, i.Request) {
+			if replayMode == ReplayModeSequential && !replayable {
+				i.Played = true
+			}
+			return i, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no matching interaction found for %s %s", r.Method, r.URL)
+}
+
 // Proxies client requests to their original destination
-func requestHandler(r *http.Request, c *cassette.Cassette, mode int) (*cassette.Interaction, error) {
+func requestHandler(r *http.Request, t *Transport) (*cassette.Interaction, error) {
+	for _, hook := range t.onRequest {
+		hook(r)
+	}
+
 	// Return interaction from cassette if in replay mode
-	if mode == ModeReplaying {
-		return c.GetInteraction(r)
+	if t.mode == ModeReplaying {
+		interaction, err := findInteraction(t.c, r, t.matcher, t.replayMode, t.replayable)
+		if err != nil {
+			return nil, err
+		}
+		for _, hook := range t.onResponse {
+			hook(interaction)
+		}
+		return interaction, nil
 	}
 
 	// Copy the original request, so we can read the form values
@@ -89,12 +332,20 @@ func requestHandler(r *http.Request, c *cassette.Cassette, mode int) (*cassette.
 
 	// Perform client request to it's original
 	// destination and record interactions
-	resp, err := http.DefaultClient.Do(r)
+	client := &http.Client{Transport: t.realTransport}
+	resp, err := client.Do(r)
 	if err != nil {
 		return nil, err
 	}
 
-	respBody, err := ioutil.ReadAll(resp.Body)
+	var respBody, bodyFile string
+	if t.streaming {
+		bodyFile, err = streamBodyToFile(t.c, resp.Body)
+	} else {
+		var b []byte
+		b, err = ioutil.ReadAll(resp.Body)
+		respBody = string(b)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -109,20 +360,35 @@ func requestHandler(r *http.Request, c *cassette.Cassette, mode int) (*cassette.
 			Method:  r.Method,
 		},
 		Response: cassette.Response{
-			Body:    string(respBody),
-			Headers: resp.Header,
-			Status:  resp.Status,
-			Code:    resp.StatusCode,
+			Body:             respBody,
+			BodyFile:         bodyFile,
+			Headers:          resp.Header,
+			Status:           resp.Status,
+			Code:             resp.StatusCode,
+			Proto:            resp.Proto,
+			ProtoMajor:       resp.ProtoMajor,
+			ProtoMinor:       resp.ProtoMinor,
+			Trailer:          resp.Trailer,
+			TransferEncoding: resp.TransferEncoding,
 		},
 	}
-	c.AddInteraction(interaction)
+
+	if err := applyFilters(interaction, t.saveFilters); err != nil {
+		return nil, err
+	}
+
+	t.c.AddInteraction(interaction)
+
+	for _, hook := range t.onResponse {
+		hook(interaction)
+	}
 
 	return interaction, nil
 }
 
 // New creates a new recorder
 func New(cassetteName string) (*Recorder, error) {
-	var mode int
+	var mode Mode
 	var c *cassette.Cassette
 	cassetteFile := fmt.Sprintf("%s.yaml", cassetteName)
 
@@ -141,16 +407,47 @@ func New(cassetteName string) (*Recorder, error) {
 		mode = ModeReplaying
 	}
 
-	// A transport which can be used by clients to inject
-	transport := &Transport{c: c, mode: mode}
+	return newRecorder(c, mode, nil), nil
+}
+
+// NewWithOptions creates a new recorder with an explicit starting mode and
+// underlying transport, instead of inferring the mode from whether the
+// cassette file already exists. This lets tests force ModeDisabled or
+// ModeReplaying regardless of what's on disk, and inject a transport other
+// than http.DefaultTransport.
+func NewWithOptions(cassetteName string, mode Mode, transport http.RoundTripper) (*Recorder, error) {
+	var c *cassette.Cassette
+	cassetteFile := fmt.Sprintf("%s.yaml", cassetteName)
+
+	if _, err := os.Stat(cassetteFile); os.IsNotExist(err) {
+		c = cassette.New(cassetteName)
+	} else {
+		c, err = cassette.Load(cassetteName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newRecorder(c, mode, transport), nil
+}
 
-	r := &Recorder{
-		mode:      mode,
-		cassette:  c,
-		Transport: transport,
+// newRecorder wires up a Recorder and its Transport around an
+// already-loaded cassette.
+func newRecorder(c *cassette.Cassette, mode Mode, transport http.RoundTripper) *Recorder {
+	if transport == nil {
+		transport = http.DefaultTransport
 	}
 
-	return r, nil
+	return &Recorder{
+		mode:     mode,
+		cassette: c,
+		Transport: &Transport{
+			c:             c,
+			mode:          mode,
+			matcher:       DefaultMatcher,
+			realTransport: transport,
+		},
+	}
 }
 
 // Stop is used to stop the recorder and save any recorded interactions
@@ -166,33 +463,90 @@ func (r *Recorder) Stop() error {
 
 // Transport either records or replays responses from a cassette, depending on its mode
 type Transport struct {
-	c    *cassette.Cassette
-	mode int
+	c               *cassette.Cassette
+	mode            Mode
+	matcher         Matcher
+	saveFilters     []Filter
+	responseFilters []Filter
+	passthroughs    []Passthrough
+	realTransport   http.RoundTripper
+	replayMode      ReplayMode
+	replayable      bool
+	streaming       bool
+	onRequest       []func(*http.Request)
+	onResponse      []func(*cassette.Interaction)
+}
+
+// isPassthrough reports whether r matches any registered Passthrough.
+func (t *Transport) isPassthrough(r *http.Request) bool {
+	for _, p := range t.passthroughs {
+		if p(r) {
+			return true
+		}
+	}
+	return false
 }
 
 // RoundTrip implements the http.RoundTripper interface
 func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
-	// Pass cassette and mode to handler, so that interactions can be
-	// retrieved or recorded depending on the current recorder mode
-	interaction, err := requestHandler(r, t.c, t.mode)
+	// ModeDisabled and passthrough rules bypass the cassette entirely.
+	if t.mode == ModeDisabled || t.isPassthrough(r) {
+		return t.realTransport.RoundTrip(r)
+	}
+
+	// t carries the cassette, mode, and every option needed to retrieve or
+	// record an interaction for the current recorder state.
+	interaction, err := requestHandler(r, t)
 
 	if err != nil {
-		panic(fmt.Errorf("Failed to process request for URL %s: %s", r.URL, err))
+		return nil, fmt.Errorf("Failed to process request for URL %s: %s", r.URL, err)
+	}
+
+	// Response filters run against a clone so they can only affect what's
+	// handed back to the caller, not the interaction already added to the
+	// cassette for persistence.
+	response := cloneInteraction(interaction)
+	if err := applyFilters(response, t.responseFilters); err != nil {
+		return nil, fmt.Errorf("Failed to process request for URL %s: %s", r.URL, err)
 	}
 
-	buf := bytes.NewBuffer([]byte(interaction.Response.Body))
+	var body io.ReadCloser
+	var contentLength int64
+	if response.Response.BodyFile != "" {
+		f, err := os.Open(response.Response.BodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to process request for URL %s: %s", r.URL, err)
+		}
+		if fi, err := f.Stat(); err == nil {
+			contentLength = fi.Size()
+		}
+		body = f
+	} else {
+		buf := bytes.NewBuffer([]byte(response.Response.Body))
+		contentLength = int64(buf.Len())
+		body = ioutil.NopCloser(buf)
+	}
+
+	// Honor the originally observed protocol version, falling back to the
+	// old HTTP/1.0 default for cassettes recorded before this field existed.
+	proto, protoMajor, protoMinor := response.Response.Proto, response.Response.ProtoMajor, response.Response.ProtoMinor
+	if proto == "" {
+		proto, protoMajor, protoMinor = "HTTP/1.0", 1, 0
+	}
 
 	return &http.Response{
-		Status:        interaction.Response.Status,
-		StatusCode:    interaction.Response.Code,
-		Proto:         "HTTP/1.0",
-		ProtoMajor:    1,
-		ProtoMinor:    0,
-		Request:       r,
-		Header:        interaction.Response.Headers,
-		Close:         true,
-		ContentLength: int64(buf.Len()),
-		Body:          ioutil.NopCloser(buf),
+		Status:           response.Response.Status,
+		StatusCode:       response.Response.Code,
+		Proto:            proto,
+		ProtoMajor:       protoMajor,
+		ProtoMinor:       protoMinor,
+		Request:          r,
+		Header:           response.Response.Headers,
+		Trailer:          response.Response.Trailer,
+		TransferEncoding: response.Response.TransferEncoding,
+		Close:            true,
+		ContentLength:    contentLength,
+		Body:             body,
 	}, nil
 }
 
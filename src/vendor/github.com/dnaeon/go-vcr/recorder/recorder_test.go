@@ -0,0 +1,203 @@
+package recorder
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dnaeon/go-vcr/cassette"
+)
+
+func TestSequentialReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcr-sequential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := cassette.New(filepath.Join(dir, "poll"))
+	c.AddInteraction(&cassette.Interaction{
+		Request:  cassette.Request{Method: "GET", URL: "http://example.com/job"},
+		Response: cassette.Response{Body: "pending", Code: 200, Status: "200 OK"},
+	})
+	c.AddInteraction(&cassette.Interaction{
+		Request:  cassette.Request{Method: "GET", URL: "http://example.com/job"},
+		Response: cassette.Response{Body: "done", Code: 200, Status: "200 OK"},
+	})
+
+	rec := newRecorder(c, ModeReplaying, nil)
+	rec.SetReplayMode(ReplayModeSequential)
+
+	req, err := http.NewRequest("GET", "http://example.com/job", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp1, err := rec.Transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := ioutil.ReadAll(resp1.Body); string(body) != "pending" {
+		t.Fatalf("expected first reply %q, got %q", "pending", body)
+	}
+
+	resp2, err := rec.Transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := ioutil.ReadAll(resp2.Body); string(body) != "done" {
+		t.Fatalf("expected second reply %q, got %q", "done", body)
+	}
+
+	if _, err := rec.Transport.RoundTrip(req); err == nil {
+		t.Fatal("expected an error once every interaction has been played")
+	}
+
+	rec.Rewind()
+
+	resp3, err := rec.Transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := ioutil.ReadAll(resp3.Body); string(body) != "pending" {
+		t.Fatalf("expected Rewind to restart replay from the first interaction, got %q", body)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestStreamingRecordsBodyToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcr-streaming")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fake := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 200,
+			Status:     "200 OK",
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader("large-file-contents")),
+		}, nil
+	})
+
+	c := cassette.New(filepath.Join(dir, "download"))
+	rec := newRecorder(c, ModeRecording, fake)
+	rec.SetStreaming(true)
+
+	req, err := http.NewRequest("GET", "http://example.com/file", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := rec.Transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "large-file-contents" {
+		t.Fatalf("expected streamed body %q, got %q", "large-file-contents", body)
+	}
+
+	interaction := c.Interactions[0]
+	if interaction.Response.BodyFile == "" {
+		t.Fatal("expected BodyFile to be set in streaming mode")
+	}
+	if interaction.Response.Body != "" {
+		t.Fatalf("expected Body to stay empty when streaming to a file, got %q", interaction.Response.Body)
+	}
+	if _, err := os.Stat(interaction.Response.BodyFile); err != nil {
+		t.Fatalf("expected body file to exist on disk: %v", err)
+	}
+}
+
+func TestCustomMatcherSeesNilBody(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcr-matcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := cassette.New(filepath.Join(dir, "nobody"))
+	c.AddInteraction(&cassette.Interaction{
+		Request:  cassette.Request{Method: "DELETE", URL: "http://example.com/item/1"},
+		Response: cassette.Response{Body: "deleted", Code: 200, Status: "200 OK"},
+	})
+
+	rec := newRecorder(c, ModeReplaying, nil)
+
+	var sawBody bool
+	rec.SetMatcher(func(r *http.Request, i cassette.Request) bool {
+		if r.Body != nil {
+			sawBody = true
+		}
+		return r.Method == i.Method && r.URL.String() == i.URL
+	})
+
+	req, err := http.NewRequest("DELETE", "http://example.com/item/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rec.Transport.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	if sawBody {
+		t.Fatal("expected matcher to see a nil body for a request with no body")
+	}
+}
+
+func TestResponseFilterDoesNotMutatePersistedInteraction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcr-response-filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fake := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 200,
+			Status:     "200 OK",
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader("ok")),
+		}, nil
+	})
+
+	c := cassette.New(filepath.Join(dir, "redact"))
+	rec := newRecorder(c, ModeRecording, fake)
+	rec.AddResponseFilter(func(i *cassette.Interaction) error {
+		i.Request.Headers.Set("Authorization", "REDACTED")
+		return nil
+	})
+
+	req, err := http.NewRequest("GET", "http://example.com/secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "secret-token")
+
+	if _, err := rec.Transport.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+
+	got := c.Interactions[0].Request.Headers.Get("Authorization")
+	if got != "secret-token" {
+		t.Fatalf("expected persisted interaction's Authorization header to stay %q, got %q", "secret-token", got)
+	}
+}